@@ -0,0 +1,148 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func putTestKey(t *testing.T, bucket, key, body string) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPut, "/"+bucket+"/"+key, strings.NewReader(body))
+	w := httptest.NewRecorder()
+	handleObjectByKey(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("PUT /%s/%s: got status %d, want 201", bucket, key, w.Code)
+	}
+}
+
+func archiveRequestBody(t *testing.T, items []archiveItem) *bytes.Reader {
+	t.Helper()
+	b, err := json.Marshal(archiveRequest{Items: items})
+	if err != nil {
+		t.Fatalf("marshaling archive request: %v", err)
+	}
+	return bytes.NewReader(b)
+}
+
+// TestArchiveZipContainsEveryEntry covers resolving both sha and
+// bucket/key items and streaming them into a real zip archive.
+func TestArchiveZipContainsEveryEntry(t *testing.T) {
+	setupTestServer(t)
+	ctx := context.Background()
+
+	sha, _, _, err := storePutAndRef(ctx, strings.NewReader("raw content"))
+	if err != nil {
+		t.Fatalf("storePutAndRef: %v", err)
+	}
+	putTestKey(t, "b", "keyed.txt", "keyed content")
+
+	req := httptest.NewRequest(http.MethodPost, "/archive?format=zip", archiveRequestBody(t, []archiveItem{
+		{SHA: sha},
+		{Bucket: "b", Key: "keyed.txt"},
+	}))
+	w := httptest.NewRecorder()
+	handleArchive(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("archive: got status %d, want 200, body: %s", w.Code, w.Body.String())
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatalf("reading zip response: %v", err)
+	}
+
+	got := map[string]string{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("opening zip entry %s: %v", f.Name, err)
+		}
+		b, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("reading zip entry %s: %v", f.Name, err)
+		}
+		got[f.Name] = string(b)
+	}
+
+	if got[sha] != "raw content" {
+		t.Fatalf("zip entry %q = %q, want %q", sha, got[sha], "raw content")
+	}
+	if got["keyed.txt"] != "keyed content" {
+		t.Fatalf("zip entry keyed.txt = %q, want %q", got["keyed.txt"], "keyed content")
+	}
+}
+
+// TestArchiveTarGzContainsEntry is the same coverage for the tar.gz format.
+func TestArchiveTarGzContainsEntry(t *testing.T) {
+	setupTestServer(t)
+	ctx := context.Background()
+
+	sha, _, _, err := storePutAndRef(ctx, strings.NewReader("tar content"))
+	if err != nil {
+		t.Fatalf("storePutAndRef: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/archive?format=tar.gz", archiveRequestBody(t, []archiveItem{{SHA: sha}}))
+	w := httptest.NewRecorder()
+	handleArchive(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("archive: got status %d, want 200, body: %s", w.Code, w.Body.String())
+	}
+
+	gzr, err := gzip.NewReader(bytes.NewReader(w.Body.Bytes()))
+	if err != nil {
+		t.Fatalf("gzip.NewReader: %v", err)
+	}
+	tr := tar.NewReader(gzr)
+	hdr, err := tr.Next()
+	if err != nil {
+		t.Fatalf("tar.Next: %v", err)
+	}
+	if hdr.Name != sha {
+		t.Fatalf("tar entry name = %q, want %q", hdr.Name, sha)
+	}
+	b, err := io.ReadAll(tr)
+	if err != nil {
+		t.Fatalf("reading tar entry: %v", err)
+	}
+	if string(b) != "tar content" {
+		t.Fatalf("tar entry content = %q, want %q", string(b), "tar content")
+	}
+}
+
+func TestArchiveRejectsInvalidSHA(t *testing.T) {
+	setupTestServer(t)
+
+	req := httptest.NewRequest(http.MethodPost, "/archive?format=zip", archiveRequestBody(t, []archiveItem{
+		{SHA: "../../etc/passwd"},
+	}))
+	w := httptest.NewRecorder()
+	handleArchive(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("archive with invalid sha: got status %d, want 400", w.Code)
+	}
+}
+
+func TestArchiveMissingReferenceReturns404(t *testing.T) {
+	setupTestServer(t)
+
+	missingSHA := strings.Repeat("a", 64)
+	req := httptest.NewRequest(http.MethodPost, "/archive?format=zip", archiveRequestBody(t, []archiveItem{
+		{SHA: missingSHA},
+	}))
+	w := httptest.NewRecorder()
+	handleArchive(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("archive with missing sha: got status %d, want 404", w.Code)
+	}
+}
@@ -0,0 +1,63 @@
+// Package backends abstracts the storage of content-addressed blobs away
+// from the HTTP layer. The bbolt index (bucket/key -> SHA mapping) always
+// stays in the main package; a Backend only knows how to store and fetch
+// blobs by their SHA-256 id.
+package backends
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Meta describes a stored blob, independent of where its bytes live.
+type Meta struct {
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+}
+
+// Backend stores and retrieves content-addressed blobs keyed by their
+// SHA-256 hex digest. Implementations must be safe for concurrent use.
+type Backend interface {
+	// Put streams r to storage, hashing as it goes, and returns the
+	// resulting SHA-256 id, size, and sniffed content type. Put is
+	// idempotent: writing the same bytes twice returns the same id.
+	Put(ctx context.Context, r io.Reader) (sha string, size int64, ct string, err error)
+
+	// Open returns a seekable reader for the blob with the given SHA-256
+	// id along with its metadata. Callers must Close the reader.
+	Open(ctx context.Context, sha string) (io.ReadSeekCloser, Meta, error)
+
+	// Stat returns metadata for a blob without opening its body.
+	Stat(ctx context.Context, sha string) (Meta, error)
+
+	// Exists reports whether a blob with the given id is present.
+	Exists(ctx context.Context, sha string) (bool, error)
+
+	// Delete removes the blob and its metadata. Deleting a missing blob
+	// is not an error.
+	Delete(ctx context.Context, sha string) error
+
+	// List returns the SHA-256 ids of every blob currently stored. It is
+	// used for GC sweeps that reconcile the index against what's
+	// actually on disk/in the bucket, so it's expected to be slow and
+	// is never on a request hot path.
+	List(ctx context.Context) ([]string, error)
+}
+
+// ErrNotExist is returned by Open/Stat when the requested blob is absent.
+var ErrNotExist = os.ErrNotExist
+
+// NewFromEnv builds a Backend from BACKEND (and the backend-specific env
+// vars it requires). BACKEND defaults to "local".
+func NewFromEnv(getenv func(k, def string) string) (Backend, error) {
+	switch kind := getenv("BACKEND", "local"); kind {
+	case "local", "":
+		return NewLocalFS(getenv("STORE_DIR", "./store")), nil
+	case "s3":
+		return NewS3FromEnv(getenv)
+	default:
+		return nil, fmt.Errorf("backends: unknown BACKEND %q", kind)
+	}
+}
@@ -0,0 +1,197 @@
+package backends
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LocalFS stores blobs as "<sha>.blob" files with a sibling "<sha>.meta.json"
+// underneath a root directory, the same layout the server has always used.
+type LocalFS struct {
+	root string
+}
+
+// NewLocalFS returns a Backend rooted at dir. dir is created lazily on
+// first write.
+func NewLocalFS(dir string) *LocalFS {
+	return &LocalFS{root: dir}
+}
+
+func (l *LocalFS) blobPath(sha string) string {
+	return filepath.Join(l.root, sha+".blob")
+}
+
+func (l *LocalFS) metaPath(sha string) string {
+	return filepath.Join(l.root, sha+".meta.json")
+}
+
+func (l *LocalFS) Put(ctx context.Context, r io.Reader) (id string, size int64, ct string, err error) {
+	h := sha256.New()
+
+	tmpDir := filepath.Join(l.root, "tmp")
+	if err := os.MkdirAll(tmpDir, 0o755); err != nil {
+		return "", 0, "", err
+	}
+
+	f, err := os.CreateTemp(tmpDir, "put-*.tmp")
+	if err != nil {
+		return "", 0, "", err
+	}
+	tmpName := f.Name()
+	defer func() {
+		f.Close()
+		os.Remove(tmpName)
+	}()
+
+	// sniff the first 512 bytes for Content-Type detection before hashing
+	var sniff [512]byte
+	n0, err := io.ReadFull(r, sniff[:])
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return "", 0, "", err
+	}
+	ct = http.DetectContentType(sniff[:n0])
+
+	if n0 > 0 {
+		if _, err := f.Write(sniff[:n0]); err != nil {
+			return "", 0, "", err
+		}
+		if _, err := h.Write(sniff[:n0]); err != nil {
+			return "", 0, "", err
+		}
+		size += int64(n0)
+	}
+
+	w := io.MultiWriter(f, h)
+	n, err := io.Copy(w, r)
+	if err != nil {
+		return "", 0, "", err
+	}
+	size += n
+
+	sum := h.Sum(nil)
+	id = hex.EncodeToString(sum)
+
+	finalPath := l.blobPath(id)
+	metaPath := l.metaPath(id)
+
+	// idempotent: identical content always maps to the same id
+	if _, err := os.Stat(finalPath); err == nil {
+		if _, err := os.Stat(metaPath); os.IsNotExist(err) {
+			meta := Meta{ContentType: ct, Size: size}
+			b, _ := json.Marshal(meta)
+			_ = os.WriteFile(metaPath, b, 0o644)
+		}
+		return id, size, ct, nil
+	} else if !os.IsNotExist(err) {
+		return "", 0, "", err
+	}
+
+	if err := f.Sync(); err != nil {
+		return "", 0, "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", 0, "", err
+	}
+	if err := os.Rename(tmpName, finalPath); err != nil {
+		return "", 0, "", err
+	}
+
+	meta := Meta{ContentType: ct, Size: size}
+	b, _ := json.Marshal(meta)
+	if err := os.WriteFile(metaPath, b, 0o644); err != nil {
+		return "", 0, "", err
+	}
+
+	return id, size, ct, nil
+}
+
+func (l *LocalFS) readMeta(sha string) (Meta, error) {
+	var m Meta
+	b, err := os.ReadFile(l.metaPath(sha))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return Meta{ContentType: "application/octet-stream"}, nil
+		}
+		return Meta{}, err
+	}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return Meta{}, err
+	}
+	return m, nil
+}
+
+func (l *LocalFS) Open(ctx context.Context, sha string) (io.ReadSeekCloser, Meta, error) {
+	f, err := os.Open(l.blobPath(sha))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, Meta{}, ErrNotExist
+		}
+		return nil, Meta{}, err
+	}
+	m, err := l.readMeta(sha)
+	if err != nil {
+		f.Close()
+		return nil, Meta{}, err
+	}
+	if st, err := f.Stat(); err == nil && m.Size == 0 {
+		m.Size = st.Size()
+	}
+	return f, m, nil
+}
+
+func (l *LocalFS) Stat(ctx context.Context, sha string) (Meta, error) {
+	st, err := os.Stat(l.blobPath(sha))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return Meta{}, ErrNotExist
+		}
+		return Meta{}, err
+	}
+	m, err := l.readMeta(sha)
+	if err != nil {
+		return Meta{}, err
+	}
+	if m.Size == 0 {
+		m.Size = st.Size()
+	}
+	return m, nil
+}
+
+func (l *LocalFS) Exists(ctx context.Context, sha string) (bool, error) {
+	if _, err := os.Stat(l.blobPath(sha)); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (l *LocalFS) List(ctx context.Context) ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(l.root, "*.blob"))
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(matches))
+	for _, m := range matches {
+		ids = append(ids, strings.TrimSuffix(filepath.Base(m), ".blob"))
+	}
+	return ids, nil
+}
+
+func (l *LocalFS) Delete(ctx context.Context, sha string) error {
+	if err := os.Remove(l.blobPath(sha)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Remove(l.metaPath(sha)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
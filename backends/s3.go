@@ -0,0 +1,320 @@
+package backends
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// S3 stores blobs as "<sha>.blob" objects in an S3-compatible bucket, with
+// metadata stored as a sibling "<sha>.meta.json" object so Stat/Open don't
+// depend on any particular S3 user-metadata behavior.
+type S3 struct {
+	client   *s3.Client
+	uploader *manager.Uploader
+	bucket   string
+}
+
+// NewS3FromEnv builds an S3 backend from S3_BUCKET, S3_ENDPOINT, S3_REGION,
+// and the standard AWS credential env vars (AWS_ACCESS_KEY_ID /
+// AWS_SECRET_ACCESS_KEY), so the same binary can target AWS or a
+// MinIO-style endpoint just by changing env vars.
+func NewS3FromEnv(getenv func(k, def string) string) (*S3, error) {
+	bucket := getenv("S3_BUCKET", "")
+	if bucket == "" {
+		return nil, errors.New("backends: S3_BUCKET is required when BACKEND=s3")
+	}
+	region := getenv("S3_REGION", "us-east-1")
+	endpoint := getenv("S3_ENDPOINT", "")
+
+	opts := []func(*awsconfig.LoadOptions) error{awsconfig.WithRegion(region)}
+	if ak, sk := getenv("AWS_ACCESS_KEY_ID", ""), getenv("AWS_SECRET_ACCESS_KEY", ""); ak != "" && sk != "" {
+		opts = append(opts, awsconfig.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(ak, sk, getenv("AWS_SESSION_TOKEN", "")),
+		))
+	}
+
+	cfg, err := awsconfig.LoadDefaultConfig(context.Background(), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("backends: loading aws config: %w", err)
+	}
+
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+			o.UsePathStyle = true
+		}
+	})
+
+	return &S3{client: client, uploader: manager.NewUploader(client), bucket: bucket}, nil
+}
+
+func (s *S3) blobKey(sha string) string { return sha + ".blob" }
+func (s *S3) metaKey(sha string) string { return sha + ".meta.json" }
+
+func randomTempKey() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "tmp/" + hex.EncodeToString(b) + ".upload", nil
+}
+
+// Put streams r straight to a temporary S3 key via manager.Uploader
+// (multipart under the hood, so it never buffers the whole object in
+// memory) while hashing it, then promotes it to its content-addressed key
+// with a server-side CopyObject — cheap, no second upload of the bytes —
+// once the SHA-256 is known. The temp object is always cleaned up.
+func (s *S3) Put(ctx context.Context, r io.Reader) (id string, size int64, ct string, err error) {
+	var sniff [512]byte
+	n0, err := io.ReadFull(r, sniff[:])
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return "", 0, "", err
+	}
+	ct = http.DetectContentType(sniff[:n0])
+
+	tmpKey, err := randomTempKey()
+	if err != nil {
+		return "", 0, "", err
+	}
+
+	h := sha256.New()
+	counted := &countingWriter{}
+	pr, pw := io.Pipe()
+	go func() {
+		mw := io.MultiWriter(pw, h, counted)
+		var werr error
+		if n0 > 0 {
+			_, werr = mw.Write(sniff[:n0])
+		}
+		if werr == nil {
+			_, werr = io.Copy(mw, r)
+		}
+		pw.CloseWithError(werr)
+	}()
+
+	_, err = s.uploader.Upload(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(tmpKey),
+		Body:   pr,
+	})
+	if err != nil {
+		return "", 0, "", fmt.Errorf("backends: s3 streaming upload: %w", err)
+	}
+	defer func() {
+		_, _ = s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+			Bucket: aws.String(s.bucket),
+			Key:    aws.String(tmpKey),
+		})
+	}()
+
+	size = counted.n
+	id = hex.EncodeToString(h.Sum(nil))
+
+	exists, err := s.Exists(ctx, id)
+	if err != nil {
+		return "", 0, "", err
+	}
+	if exists {
+		return id, size, ct, nil
+	}
+
+	if _, err := s.client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:            aws.String(s.bucket),
+		CopySource:        aws.String(s.bucket + "/" + tmpKey),
+		Key:               aws.String(s.blobKey(id)),
+		ContentType:       aws.String(ct),
+		MetadataDirective: types.MetadataDirectiveReplace,
+	}); err != nil {
+		return "", 0, "", fmt.Errorf("backends: s3 promote blob: %w", err)
+	}
+
+	metaBytes, _ := json.Marshal(Meta{ContentType: ct, Size: size})
+	if _, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(s.metaKey(id)),
+		Body:        bytes.NewReader(metaBytes),
+		ContentType: aws.String("application/json"),
+	}); err != nil {
+		return "", 0, "", fmt.Errorf("backends: s3 put meta: %w", err)
+	}
+
+	return id, size, ct, nil
+}
+
+// countingWriter tracks bytes written so Put can report size without
+// buffering the stream it's hashing and uploading.
+type countingWriter struct{ n int64 }
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
+
+func (s *S3) readMeta(ctx context.Context, sha string) (Meta, error) {
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.metaKey(sha)),
+	})
+	if err != nil {
+		var nsk *types.NoSuchKey
+		if errors.As(err, &nsk) {
+			return Meta{ContentType: "application/octet-stream"}, nil
+		}
+		return Meta{}, err
+	}
+	defer out.Body.Close()
+
+	var m Meta
+	if err := json.NewDecoder(out.Body).Decode(&m); err != nil {
+		return Meta{}, err
+	}
+	return m, nil
+}
+
+// s3Reader adapts an S3 GetObject body (io.ReadCloser) to io.ReadSeekCloser
+// by spooling it to a temp file on local disk rather than buffering it in
+// memory — callers like /archive stream potentially many large blobs
+// through Open concurrently, and a multi-GB object has no business living
+// in process RAM just to be re-streamed out again.
+type s3Reader struct {
+	*os.File
+}
+
+func (r *s3Reader) Close() error {
+	name := r.File.Name()
+	closeErr := r.File.Close()
+	if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+		if closeErr == nil {
+			return err
+		}
+	}
+	return closeErr
+}
+
+func (s *S3) Open(ctx context.Context, sha string) (io.ReadSeekCloser, Meta, error) {
+	m, err := s.Stat(ctx, sha)
+	if err != nil {
+		return nil, Meta{}, err
+	}
+
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.blobKey(sha)),
+	})
+	if err != nil {
+		var nsk *types.NoSuchKey
+		if errors.As(err, &nsk) {
+			return nil, Meta{}, ErrNotExist
+		}
+		return nil, Meta{}, err
+	}
+	defer out.Body.Close()
+
+	f, err := os.CreateTemp("", "gobject-s3-open-*")
+	if err != nil {
+		return nil, Meta{}, err
+	}
+	if _, err := io.Copy(f, out.Body); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, Meta{}, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, Meta{}, err
+	}
+
+	return &s3Reader{File: f}, m, nil
+}
+
+func (s *S3) Stat(ctx context.Context, sha string) (Meta, error) {
+	head, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.blobKey(sha)),
+	})
+	if err != nil {
+		var nf *types.NotFound
+		if errors.As(err, &nf) {
+			return Meta{}, ErrNotExist
+		}
+		return Meta{}, err
+	}
+
+	m, err := s.readMeta(ctx, sha)
+	if err != nil {
+		return Meta{}, err
+	}
+	if m.Size == 0 && head.ContentLength != nil {
+		m.Size = *head.ContentLength
+	}
+	return m, nil
+}
+
+func (s *S3) Exists(ctx context.Context, sha string) (bool, error) {
+	_, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.blobKey(sha)),
+	})
+	if err != nil {
+		var nf *types.NotFound
+		if errors.As(err, &nf) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *S3) List(ctx context.Context) ([]string, error) {
+	var ids []string
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("backends: s3 list: %w", err)
+		}
+		for _, obj := range page.Contents {
+			key := aws.ToString(obj.Key)
+			if sha, ok := strings.CutSuffix(key, ".blob"); ok {
+				ids = append(ids, sha)
+			}
+		}
+	}
+	return ids, nil
+}
+
+func (s *S3) Delete(ctx context.Context, sha string) error {
+	if _, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.blobKey(sha)),
+	}); err != nil {
+		return fmt.Errorf("backends: s3 delete blob: %w", err)
+	}
+	if _, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.metaKey(sha)),
+	}); err != nil {
+		return fmt.Errorf("backends: s3 delete meta: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// blobLocks serializes, per SHA, the window between a bolt transaction
+// that changes a blob's refcount and the backend Put/Delete call that
+// acts on that decision. Without it, a decrement-to-zero transaction
+// commits, then — before the caller gets around to deleting the backend
+// blob — a concurrent PUT/POST of the exact same content dedupes onto
+// that SHA and increments its refcount back to 1 in its own transaction,
+// returning 201 to its caller; the original caller's deferred delete then
+// runs anyway and destroys the blob the second caller was just told was
+// stored. Holding this lock across both the refcount transaction and the
+// backend mutation, on every path that touches a given SHA's refcount,
+// keeps those two sequences from interleaving.
+var blobLocks keyedMutex
+
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+// Lock acquires the mutex for key, creating it on first use, and returns
+// a func to release it.
+func (k *keyedMutex) Lock(key string) func() {
+	k.mu.Lock()
+	if k.locks == nil {
+		k.locks = make(map[string]*sync.Mutex)
+	}
+	l, ok := k.locks[key]
+	if !ok {
+		l = &sync.Mutex{}
+		k.locks[key] = l
+	}
+	k.mu.Unlock()
+
+	l.Lock()
+	return l.Unlock
+}
+
+// lockBlobs acquires blobLocks for every distinct, non-empty SHA in shas,
+// always in sorted order so callers that lock more than one SHA at once
+// (PUT overwrite locks both the old and new blob) can never deadlock
+// against each other. It returns a func that releases all of them.
+func lockBlobs(shas ...string) func() {
+	seen := make(map[string]struct{}, len(shas))
+	var keys []string
+	for _, sha := range shas {
+		if sha == "" {
+			continue
+		}
+		if _, ok := seen[sha]; ok {
+			continue
+		}
+		seen[sha] = struct{}{}
+		keys = append(keys, sha)
+	}
+	sort.Strings(keys)
+
+	unlocks := make([]func(), len(keys))
+	for i, sha := range keys {
+		unlocks[i] = blobLocks.Lock(sha)
+	}
+	return func() {
+		for i := len(unlocks) - 1; i >= 0; i-- {
+			unlocks[i]()
+		}
+	}
+}
+
+// dropBlobIfUnreferenced deletes sha from the backend, but only after
+// re-confirming — under the caller's blobLocks hold — that its refcount
+// is still zero. Callers must hold lockBlobs(sha) (possibly alongside
+// other SHAs) before calling this, closing the race a bare "decrement,
+// then delete" sequence would otherwise leave open.
+func dropBlobIfUnreferenced(ctx context.Context, sha string) (deleted bool, err error) {
+	var stillZero bool
+	if err := indexDB.View(func(tx *bolt.Tx) error {
+		stillZero = getRefCount(tx, sha) == 0
+		return nil
+	}); err != nil {
+		return false, err
+	}
+	if !stillZero {
+		return false, nil
+	}
+	if err := store.Delete(ctx, sha); err != nil {
+		return false, err
+	}
+	return true, nil
+}
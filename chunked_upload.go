@@ -0,0 +1,224 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// uploadPartSize and uploadMaxParts bound parallel chunked uploads so a
+// client can shard a multi-GB object into ~32 MiB parts (the default) and
+// push them concurrently over a lossy link, resuming individual failed
+// parts instead of the whole object.
+var (
+	uploadPartSize = mustParseSize(getenv("UPLOAD_PART_SIZE", "33554432"))
+	uploadMaxParts = mustParseSize(getenv("UPLOAD_MAX_PARTS", "10000"))
+)
+
+func mustParseSize(s string) int64 {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		panic(fmt.Sprintf("invalid size %q: %v", s, err))
+	}
+	return n
+}
+
+type createUploadResponse struct {
+	UploadID string `json:"upload_id"`
+}
+
+type completeUploadRequest struct {
+	Parts []int `json:"parts"`
+}
+
+func uploadDir(id string) string {
+	return filepath.Join(storeDir, "tmp", id)
+}
+
+func partPath(id string, n int) string {
+	return filepath.Join(uploadDir(id), strconv.Itoa(n)+".part")
+}
+
+// contentRangeSize extracts the byte count described by a "bytes
+// start-end/total" Content-Range header, returning ok=false if it isn't
+// parseable in that form.
+func contentRangeSize(headerVal string) (int64, bool) {
+	v := strings.TrimPrefix(headerVal, "bytes ")
+	rangePart, _, found := strings.Cut(v, "/")
+	if !found {
+		return 0, false
+	}
+	start, end, found := strings.Cut(rangePart, "-")
+	if !found {
+		return 0, false
+	}
+	startN, err1 := strconv.ParseInt(start, 10, 64)
+	endN, err2 := strconv.ParseInt(end, 10, 64)
+	if err1 != nil || err2 != nil || endN < startN {
+		return 0, false
+	}
+	return endN - startN + 1, true
+}
+
+// handleUploadsCreate handles POST /uploads, allocating an upload id that
+// subsequent part PUTs and the final complete call are scoped to.
+func handleUploadsCreate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := newTusUploadID()
+	if err != nil {
+		http.Error(w, "failed to allocate upload id", http.StatusInternalServerError)
+		return
+	}
+	if err := os.MkdirAll(uploadDir(id), 0o755); err != nil {
+		http.Error(w, "failed to prepare upload", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(createUploadResponse{UploadID: id})
+}
+
+// handleUploadsDispatch routes PUT /uploads/{id}/parts/{n} and
+// POST /uploads/{id}/complete.
+func handleUploadsDispatch(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/uploads/")
+	segs := strings.Split(path, "/")
+
+	if len(segs) < 2 || !uploadIDRe.MatchString(segs[0]) {
+		http.Error(w, "invalid upload id", http.StatusBadRequest)
+		return
+	}
+
+	switch {
+	case len(segs) == 3 && segs[1] == "parts" && r.Method == http.MethodPut:
+		handleUploadPart(w, r, segs[0], segs[2])
+	case len(segs) == 2 && segs[1] == "complete" && r.Method == http.MethodPost:
+		handleUploadComplete(w, r, segs[0])
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+	}
+}
+
+func handleUploadPart(w http.ResponseWriter, r *http.Request, id, nStr string) {
+	n, err := strconv.Atoi(nStr)
+	if err != nil || n < 0 {
+		http.Error(w, "invalid part number", http.StatusBadRequest)
+		return
+	}
+	if int64(n) >= uploadMaxParts {
+		http.Error(w, "too many parts", http.StatusBadRequest)
+		return
+	}
+	if _, err := os.Stat(uploadDir(id)); err != nil {
+		http.Error(w, "upload not found", http.StatusNotFound)
+		return
+	}
+
+	defer r.Body.Close()
+	h := sha256.New()
+	tmp, err := os.CreateTemp(uploadDir(id), "part-*.tmp")
+	if err != nil {
+		http.Error(w, "failed to stage part", http.StatusInternalServerError)
+		return
+	}
+	tmpName := tmp.Name()
+	defer func() {
+		tmp.Close()
+		os.Remove(tmpName)
+	}()
+
+	size, err := io.Copy(io.MultiWriter(tmp, h), io.LimitReader(r.Body, uploadPartSize+1))
+	if err != nil {
+		http.Error(w, "failed to read part", http.StatusInternalServerError)
+		return
+	}
+	if size > uploadPartSize {
+		http.Error(w, "part exceeds UPLOAD_PART_SIZE", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if cr := r.Header.Get("Content-Range"); cr != "" {
+		if wantSize, ok := contentRangeSize(cr); ok && wantSize != size {
+			http.Error(w, "part size disagrees with Content-Range", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if want := r.Header.Get("X-Part-SHA256"); want != "" {
+		got := hex.EncodeToString(h.Sum(nil))
+		if subtle.ConstantTimeCompare([]byte(got), []byte(want)) != 1 {
+			http.Error(w, "part checksum mismatch", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := tmp.Close(); err != nil {
+		http.Error(w, "failed to stage part", http.StatusInternalServerError)
+		return
+	}
+	if err := os.Rename(tmpName, partPath(id, n)); err != nil {
+		http.Error(w, "failed to store part", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleUploadComplete concatenates the named parts, in order, into a
+// single reader and pushes it through the configured Backend so the
+// result gets the same SHA-256 hashing, atomic rename, and meta sidecar
+// as the single-shot upload path.
+func handleUploadComplete(w http.ResponseWriter, r *http.Request, id string) {
+	defer r.Body.Close()
+
+	var req completeUploadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Parts) == 0 {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	sorted := append([]int(nil), req.Parts...)
+	sort.Ints(sorted)
+
+	readers := make([]io.Reader, 0, len(sorted))
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	for _, n := range sorted {
+		f, err := os.Open(partPath(id, n))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("missing part %d", n), http.StatusBadRequest)
+			return
+		}
+		files = append(files, f)
+		readers = append(readers, f)
+	}
+
+	sha, size, ct, err := storePutAndRef(r.Context(), io.MultiReader(readers...))
+	if err != nil {
+		http.Error(w, "failed to store object", http.StatusInternalServerError)
+		return
+	}
+
+	os.RemoveAll(uploadDir(id))
+
+	resp := PutResponse{ID: sha, Size: size, ContentType: ct}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func createTestUpload(t *testing.T) string {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/uploads", nil)
+	w := httptest.NewRecorder()
+	handleUploadsCreate(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /uploads: got status %d, want 200", w.Code)
+	}
+	var resp createUploadResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("decoding create response: %v", err)
+	}
+	return resp.UploadID
+}
+
+func putTestPart(t *testing.T, id string, n int, data string) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPut, "/uploads/"+id+"/parts/"+strconv.Itoa(n), strings.NewReader(data))
+	w := httptest.NewRecorder()
+	handleUploadsDispatch(w, req)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("PUT part %d: got status %d, want 204, body: %s", n, w.Code, w.Body.String())
+	}
+}
+
+// TestChunkedUploadCompleteConcatenatesPartsInOrder verifies that parts
+// uploaded out of order are reassembled by part number, not upload order,
+// and that the result hashes and stores exactly like a single-shot PUT.
+func TestChunkedUploadCompleteConcatenatesPartsInOrder(t *testing.T) {
+	setupTestServer(t)
+
+	id := createTestUpload(t)
+	putTestPart(t, id, 1, "world")
+	putTestPart(t, id, 0, "hello ")
+
+	want := "hello world"
+	sum := sha256.Sum256([]byte(want))
+	wantSHA := hex.EncodeToString(sum[:])
+
+	body, _ := json.Marshal(completeUploadRequest{Parts: []int{1, 0}})
+	req := httptest.NewRequest(http.MethodPost, "/uploads/"+id+"/complete", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+	handleUploadsDispatch(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("complete: got status %d, want 200, body: %s", w.Code, w.Body.String())
+	}
+
+	var resp PutResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("decoding complete response: %v", err)
+	}
+	if resp.ID != wantSHA {
+		t.Fatalf("complete: id = %q, want %q", resp.ID, wantSHA)
+	}
+
+	if exists, err := store.Exists(req.Context(), wantSHA); err != nil || !exists {
+		t.Fatalf("blob %s missing after chunked upload completion (exists=%v, err=%v)", wantSHA, exists, err)
+	}
+}
+
+func TestChunkedUploadPartChecksumMismatchRejected(t *testing.T) {
+	setupTestServer(t)
+
+	id := createTestUpload(t)
+	req := httptest.NewRequest(http.MethodPut, "/uploads/"+id+"/parts/0", strings.NewReader("some data"))
+	req.Header.Set("X-Part-SHA256", strings.Repeat("0", 64))
+	w := httptest.NewRecorder()
+	handleUploadsDispatch(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("part with bad checksum: got status %d, want 400", w.Code)
+	}
+}
+
+func TestChunkedUploadRejectsPathTraversalID(t *testing.T) {
+	setupTestServer(t)
+
+	req := httptest.NewRequest(http.MethodPut, "/uploads/../../etc/parts/0", strings.NewReader("x"))
+	w := httptest.NewRecorder()
+	handleUploadsDispatch(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("PUT with path-traversal upload id: got status %d, want 400", w.Code)
+	}
+}
+
+func TestChunkedUploadCompleteMissingPartFails(t *testing.T) {
+	setupTestServer(t)
+
+	id := createTestUpload(t)
+	putTestPart(t, id, 0, "only part")
+
+	body, _ := json.Marshal(completeUploadRequest{Parts: []int{0, 1}})
+	req := httptest.NewRequest(http.MethodPost, "/uploads/"+id+"/complete", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+	handleUploadsDispatch(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("complete with missing part: got status %d, want 400", w.Code)
+	}
+}
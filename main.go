@@ -1,8 +1,8 @@
 package main
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
+	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -14,32 +14,49 @@ import (
 	"time"
 
 	bolt "go.etcd.io/bbolt"
+
+	"github.com/Retasusan/gobject/backends"
 )
 
+// PutResponse is returned by POST /objects. It has no delete_key or
+// expiry fields — see the handler's doc comment in main() for why bare
+// content-addressed uploads are permanent rather than TTL'd/revocable
+// like PUT /{bucket}/{key}.
 type PutResponse struct {
 	ID          string `json:"id"`
 	Size        int64  `json:"size"`
 	ContentType string `json:"content_type"`
 }
 
-type Meta struct {
-	ContentType string `json:"content_type"`
-	Size        int64  `json:"size"`
-}
-
 type IndexEntry struct {
 	SHA         string    `json:"sha"`
 	Size        int64     `json:"size"`
 	ContentType string    `json:"content_type"`
 	ModTime     time.Time `json:"mod_time"`
+	ExpiresAt   time.Time `json:"expires_at,omitempty"`
+	DeleteKey   string    `json:"delete_key,omitempty"`
+}
+
+// expired reports whether e has a TTL that has already passed. Entries
+// without an ExpiresAt never expire.
+func (e IndexEntry) expired(now time.Time) bool {
+	return !e.ExpiresAt.IsZero() && now.After(e.ExpiresAt)
 }
 
 var idRe = regexp.MustCompile(`^[0-9a-f]{64}$`)
 
+// uploadIDRe matches the hex ids newTusUploadID hands out for both tus
+// and chunked uploads. Those ids get joined straight onto storeDir/tmp/...
+// paths, so anything id-shaped that isn't this format is rejected before
+// it ever reaches the filesystem.
+var uploadIDRe = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
 var storeDir = getenv("STORE_DIR", "./store")
 
 var indexDB *bolt.DB
 
+var store backends.Backend
+
 func main() {
 	var err error
 	indexDB, err = openIndexDB(filepath.Join(storeDir, "index.db"))
@@ -52,6 +69,17 @@ func main() {
 		panic(err)
 	}
 
+	store, err = backends.NewFromEnv(getenv)
+	if err != nil {
+		panic(err)
+	}
+
+	sweepInterval, err := time.ParseDuration(getenv("SWEEP_INTERVAL", "5m"))
+	if err != nil {
+		panic(err)
+	}
+	startExpirySweeper(sweepInterval)
+
 	mux := http.NewServeMux()
 
 	// health check
@@ -61,6 +89,15 @@ func main() {
 	})
 
 	// POST /object
+	//
+	// Unlike PUT /{bucket}/{key}, this path never gets an X-Delete-Key or
+	// TTL: a bare content-addressed put has no bucket/key binding for an
+	// expiry sweeper to act on, and recordDirectPut's pins bucket (see
+	// refs.go) exists specifically to make these uploads reachable
+	// forever by SHA alone. Layering a per-upload delete key on top would
+	// mean decrementing pins, which the GC rebuild deliberately never
+	// does. Callers that need expiry or revocation should go through
+	// PUT /{bucket}/{key} instead.
 	mux.HandleFunc("/objects", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -69,7 +106,7 @@ func main() {
 
 		defer r.Body.Close()
 
-		id, size, ct, err := putAtomicStream(storeDir, r.Body)
+		id, size, ct, err := storePutAndRef(r.Context(), r.Body)
 		if err != nil {
 			http.Error(w, "failed to store object", http.StatusInternalServerError)
 			return
@@ -94,10 +131,9 @@ func main() {
 			return
 		}
 
-		path := filepath.Join(storeDir, id+".blob")
-		f, err := os.Open(path)
+		f, meta, err := store.Open(r.Context(), id)
 		if err != nil {
-			if os.IsNotExist(err) {
+			if err == backends.ErrNotExist {
 				http.Error(w, "not found", http.StatusNotFound)
 				return
 			}
@@ -106,28 +142,28 @@ func main() {
 		}
 		defer f.Close()
 
-		// Content-Type は meta から（既に実装済み）
-		metaPath := filepath.Join(storeDir, id+".meta.json")
-		if b, err := os.ReadFile(metaPath); err == nil {
-			var m Meta
-			if json.Unmarshal(b, &m) == nil && m.ContentType != "" {
-				w.Header().Set("Content-Type", m.ContentType)
-			}
+		if meta.ContentType != "" {
+			w.Header().Set("Content-Type", meta.ContentType)
 		} else {
 			w.Header().Set("Content-Type", "application/octet-stream")
 		}
 
-		// modTime はキャッシュ/Range用に必要
-		st, err := f.Stat()
-		if err != nil {
-			http.Error(w, "stat failed", http.StatusInternalServerError)
-			return
-		}
-
 		// ここが核心：Range/HEAD/206 を全部やってくれる
-		http.ServeContent(w, r, id, st.ModTime(), f)
+		http.ServeContent(w, r, id, time.Time{}, f)
 	})
 
+	// resumable uploads (tus 1.0)
+	mux.HandleFunc("/files/", handleTus)
+
+	// parallel chunked uploads for very large objects
+	mux.HandleFunc("/uploads", handleUploadsCreate)
+	mux.HandleFunc("/uploads/", handleUploadsDispatch)
+
+	mux.HandleFunc("/admin/gc", handleAdminGC)
+
+	// server-side archive assembly for multi-object downloads
+	mux.HandleFunc("/archive", handleArchive)
+
 	mux.HandleFunc("/", handleObjectByKey)
 
 	addr := getenv("LISTEN_ADDR", ":8080")
@@ -149,100 +185,43 @@ func getenv(k, def string) string {
 	return def
 }
 
-func putAtomicStream(storeDir string, r io.Reader) (id string, size int64, ct string, err error) {
-	h := sha256.New()
-
-	tmpDir := filepath.Join(storeDir, "tmp")
-	if err := os.MkdirAll(tmpDir, 0o755); err != nil {
-		return "", 0, "", err
-	}
-
-	f, err := os.CreateTemp(tmpDir, "put-*.tmp")
+func openIndexDB(path string) (*bolt.DB, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 1 * time.Second})
 	if err != nil {
-		return "", 0, "", err
-	}
-	tmpName := f.Name()
-	defer func() {
-		f.Close()
-		os.Remove(tmpName)
-	}()
-
-	// --- 先頭512bytesだけ読む（Content-Type 判定用）---
-	var sniff [512]byte
-	n0, err := io.ReadFull(r, sniff[:])
-	if err != nil && err != io.ErrUnexpectedEOF {
-		return "", 0, "", err
+		return nil, err
 	}
-	ct = http.DetectContentType(sniff[:n0])
-
-	// 先頭分はすでに読んだので、ファイル＆ハッシュにまず書く
-	if n0 > 0 {
-		if _, err := f.Write(sniff[:n0]); err != nil {
-			return "", 0, "", err
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, e := tx.CreateBucketIfNotExists([]byte("objects")); e != nil {
+			return e
 		}
-		if _, err := h.Write(sniff[:n0]); err != nil {
-			return "", 0, "", err
+		if _, e := tx.CreateBucketIfNotExists([]byte(refsBucket)); e != nil {
+			return e
 		}
-		size += int64(n0)
-	}
+		_, e := tx.CreateBucketIfNotExists([]byte(pinsBucket))
+		return e
+	})
+	return db, err
+}
 
-	// 残りをストリーミング
-	w := io.MultiWriter(f, h)
-	n, err := io.Copy(w, r)
+// storePutAndRef stores r through the configured Backend and pins the
+// resulting blob so it stays reachable forever by SHA alone — used by
+// every upload path that hands the caller a bare content-addressed id
+// with no bucket/key binding (POST /objects, tus, chunked uploads).
+func storePutAndRef(ctx context.Context, r io.Reader) (sha string, size int64, ct string, err error) {
+	sha, size, ct, err = store.Put(ctx, r)
 	if err != nil {
 		return "", 0, "", err
 	}
-	size += n
-
-	sum := h.Sum(nil)
-	id = hex.EncodeToString(sum)
 
-	finalPath := filepath.Join(storeDir, id+".blob")
-	metaPath := filepath.Join(storeDir, id+".meta.json")
+	unlock := lockBlobs(sha)
+	defer unlock()
 
-	// 冪等
-	if _, err := os.Stat(finalPath); err == nil {
-		// meta が無ければ作る（安全）
-		if _, err := os.Stat(metaPath); os.IsNotExist(err) {
-			meta := Meta{ContentType: ct, Size: size}
-			b, _ := json.Marshal(meta)
-			_ = os.WriteFile(metaPath, b, 0o644)
-		}
-		return id, size, ct, nil
-	} else if !os.IsNotExist(err) {
+	if err := indexDB.Update(func(tx *bolt.Tx) error {
+		return recordDirectPut(tx, sha)
+	}); err != nil {
 		return "", 0, "", err
 	}
-
-	if err := f.Sync(); err != nil {
-		return "", 0, "", err
-	}
-	if err := f.Close(); err != nil {
-		return "", 0, "", err
-	}
-	if err := os.Rename(tmpName, finalPath); err != nil {
-		return "", 0, "", err
-	}
-
-	// meta 保存
-	meta := Meta{ContentType: ct, Size: size}
-	b, _ := json.Marshal(meta)
-	if err := os.WriteFile(metaPath, b, 0o644); err != nil {
-		return "", 0, "", err
-	}
-
-	return id, size, ct, nil
-}
-
-func openIndexDB(path string) (*bolt.DB, error) {
-	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 1 * time.Second})
-	if err != nil {
-		return nil, err
-	}
-	err = db.Update(func(tx *bolt.Tx) error {
-		_, e := tx.CreateBucketIfNotExists([]byte("objects"))
-		return e
-	})
-	return db, err
+	return sha, size, ct, nil
 }
 
 func handleObjectByKey(w http.ResponseWriter, r *http.Request) {
@@ -260,30 +239,80 @@ func handleObjectByKey(w http.ResponseWriter, r *http.Request) {
 	case http.MethodPut:
 		defer r.Body.Close()
 
-		sha, size, ct, err := putAtomicStream(storeDir, r.Body)
+		expiresAt, err := parseExpiry(r.Header)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		sha, size, ct, err := store.Put(r.Context(), r.Body)
 		if err != nil {
 			http.Error(w, "put failed", http.StatusInternalServerError)
 			return
 		}
 
+		deleteKey, err := generateDeleteKey()
+		if err != nil {
+			http.Error(w, "failed to allocate delete key", http.StatusInternalServerError)
+			return
+		}
+
 		entry := IndexEntry{
 			SHA:         sha,
 			Size:        size,
 			ContentType: ct,
 			ModTime:     time.Now().UTC(),
+			ExpiresAt:   expiresAt,
+			DeleteKey:   deleteKey,
 		}
 		val, _ := json.Marshal(entry)
 
+		// Peek at the current entry so we know which SHAs to lock before
+		// the refcount transaction runs; the transaction below re-reads
+		// it for the authoritative value.
+		var peeked IndexEntry
+		_ = indexDB.View(func(tx *bolt.Tx) error {
+			if v := tx.Bucket([]byte("objects")).Get(indexKey); v != nil {
+				_ = json.Unmarshal(v, &peeked)
+			}
+			return nil
+		})
+
+		unlock := lockBlobs(peeked.SHA, sha)
+		defer unlock()
+
+		var orphanedSHA string
 		err = indexDB.Update(func(tx *bolt.Tx) error {
 			b := tx.Bucket([]byte("objects"))
+
+			var old IndexEntry
+			if v := b.Get(indexKey); v != nil && json.Unmarshal(v, &old) == nil && old.SHA != sha {
+				newCount, err := decrRef(tx, old.SHA)
+				if err != nil {
+					return err
+				}
+				if newCount == 0 {
+					orphanedSHA = old.SHA
+				}
+			}
+			if old.SHA != sha {
+				if err := incrRef(tx, sha); err != nil {
+					return err
+				}
+			}
+
 			return b.Put(indexKey, val)
 		})
 		if err != nil {
 			http.Error(w, "index failed", http.StatusInternalServerError)
 			return
 		}
+		if orphanedSHA != "" {
+			_, _ = dropBlobIfUnreferenced(r.Context(), orphanedSHA)
+		}
 
 		w.Header().Set("ETag", sha)
+		w.Header().Set("X-Delete-Key", deleteKey)
 		w.WriteHeader(http.StatusCreated)
 		return
 	case http.MethodGet, http.MethodHead:
@@ -296,12 +325,12 @@ func handleObjectByKey(w http.ResponseWriter, r *http.Request) {
 			}
 			return json.Unmarshal(v, &entry)
 		})
-		if err != nil {
+		if err != nil || entry.expired(time.Now().UTC()) {
 			http.Error(w, "not found", http.StatusNotFound)
 			return
 		}
 
-		f, err := os.Open(filepath.Join(storeDir, entry.SHA+".blob"))
+		f, _, err := store.Open(r.Context(), entry.SHA)
 		if err != nil {
 			http.Error(w, "blob missing", http.StatusInternalServerError)
 			return
@@ -311,18 +340,47 @@ func handleObjectByKey(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", entry.ContentType)
 		w.Header().Set("ETag", entry.SHA)
 
-		_, _ = f.Stat()
 		http.ServeContent(w, r, key, entry.ModTime, f)
 		return
 	case http.MethodDelete:
-		err := indexDB.Update(func(tx *bolt.Tx) error {
+		var entry IndexEntry
+		err := indexDB.View(func(tx *bolt.Tx) error {
+			b := tx.Bucket([]byte("objects"))
+			v := b.Get(indexKey)
+			if v == nil {
+				return os.ErrNotExist
+			}
+			return json.Unmarshal(v, &entry)
+		})
+		if err != nil {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		if entry.DeleteKey != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Delete-Key")), []byte(entry.DeleteKey)) != 1 {
+			http.Error(w, "invalid or missing X-Delete-Key", http.StatusForbidden)
+			return
+		}
+
+		unlock := lockBlobs(entry.SHA)
+		defer unlock()
+
+		var orphaned bool
+		err = indexDB.Update(func(tx *bolt.Tx) error {
 			b := tx.Bucket([]byte("objects"))
+			newCount, err := decrRef(tx, entry.SHA)
+			if err != nil {
+				return err
+			}
+			orphaned = newCount == 0
 			return b.Delete(indexKey)
 		})
 		if err != nil {
 			http.Error(w, "delete failed", http.StatusInternalServerError)
 			return
 		}
+		if orphaned {
+			_, _ = dropBlobIfUnreferenced(r.Context(), entry.SHA)
+		}
 		w.WriteHeader(http.StatusNoContent)
 		return
 
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/binary"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// refs maps a blob's SHA-256 id to the number of things pointing at it:
+// bucket/key index entries (handleObjectByKey) and direct content-addressed
+// puts (POST /objects, finished tus uploads, finished chunked uploads).
+// Because Backend.Put dedupes by content hash, one blob can be shared by
+// many of either, and refs is what lets DELETE, the expiry sweeper, and
+// GC know when it's finally safe to unlink the blob.
+const refsBucket = "refs"
+
+// pins records how many times a blob was uploaded through a route that
+// exposes it forever by SHA alone (POST /objects, tus, chunked uploads) —
+// those uploads never go through handleObjectByKey, so they're never
+// subject to key overwrite/delete/expiry and have no other durable record
+// of being "in use". Unlike refs, pins is never cleared or decremented; it
+// is the source of truth rebuildRefCounts replays so a GC rebuild doesn't
+// mistake a bare /objects upload for an orphan.
+const pinsBucket = "pins"
+
+func getCountIn(b *bolt.Bucket, sha string) uint64 {
+	v := b.Get([]byte(sha))
+	if v == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(v)
+}
+
+// setRefCountIn is the bucket-level primitive every refcount mutation
+// (refs or pins) goes through.
+func setRefCountIn(b *bolt.Bucket, sha string, n uint64) error {
+	if n == 0 {
+		return b.Delete([]byte(sha))
+	}
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, n)
+	return b.Put([]byte(sha), buf)
+}
+
+func getRefCount(tx *bolt.Tx, sha string) uint64 {
+	return getCountIn(tx.Bucket([]byte(refsBucket)), sha)
+}
+
+func setRefCount(tx *bolt.Tx, sha string, n uint64) error {
+	return setRefCountIn(tx.Bucket([]byte(refsBucket)), sha, n)
+}
+
+// incrRef bumps sha's refcount by one within tx.
+func incrRef(tx *bolt.Tx, sha string) error {
+	return setRefCount(tx, sha, getRefCount(tx, sha)+1)
+}
+
+// decrRef drops sha's refcount by one (floored at zero) within tx and
+// returns the count after decrementing, so callers can tell whether the
+// blob is now safe to delete once the transaction commits.
+func decrRef(tx *bolt.Tx, sha string) (uint64, error) {
+	cur := getRefCount(tx, sha)
+	if cur == 0 {
+		return 0, nil
+	}
+	next := cur - 1
+	return next, setRefCount(tx, sha, next)
+}
+
+// recordDirectPut pins sha as reachable forever (it bumps both the
+// durable pins bucket and the live refs counter) for a blob that was just
+// stored through a route with no bucket/key binding.
+func recordDirectPut(tx *bolt.Tx, sha string) error {
+	pb := tx.Bucket([]byte(pinsBucket))
+	if err := setRefCountIn(pb, sha, getCountIn(pb, sha)+1); err != nil {
+		return err
+	}
+	return incrRef(tx, sha)
+}
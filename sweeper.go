@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// startExpirySweeper runs sweepExpired on interval until the process
+// exits. GET/HEAD already reject expired entries on their own, so the
+// sweeper's job is purely to reclaim disk: drop expired index entries and,
+// once no other key references their SHA, delete the underlying blob.
+func startExpirySweeper(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		for range ticker.C {
+			if err := sweepExpired(context.Background()); err != nil {
+				log.Printf("expiry sweep failed: %v", err)
+			}
+		}
+	}()
+}
+
+func sweepExpired(ctx context.Context) error {
+	now := time.Now().UTC()
+
+	// Collect which SHAs expired entries reference before locking them,
+	// the same way handleObjectByKey does, so the decrement-then-delete
+	// below can't race a concurrent claim of the same content.
+	var candidateSHAs []string
+	err := indexDB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("objects"))
+		return b.ForEach(func(_, v []byte) error {
+			var e IndexEntry
+			if json.Unmarshal(v, &e) == nil && e.expired(now) {
+				candidateSHAs = append(candidateSHAs, e.SHA)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("sweep: scanning for expired index entries: %w", err)
+	}
+
+	unlock := lockBlobs(candidateSHAs...)
+	defer unlock()
+
+	var orphaned []string
+	err = indexDB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("objects"))
+		var deadKeys [][]byte
+		var deadEntries []IndexEntry
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var e IndexEntry
+			if json.Unmarshal(v, &e) != nil {
+				continue
+			}
+			if e.expired(now) {
+				deadKeys = append(deadKeys, append([]byte(nil), k...))
+				deadEntries = append(deadEntries, e)
+			}
+		}
+		for i, k := range deadKeys {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+			newCount, err := decrRef(tx, deadEntries[i].SHA)
+			if err != nil {
+				return err
+			}
+			if newCount == 0 {
+				orphaned = append(orphaned, deadEntries[i].SHA)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("sweep: removing expired index entries: %w", err)
+	}
+
+	for _, sha := range orphaned {
+		if deleted, err := dropBlobIfUnreferenced(ctx, sha); err != nil {
+			log.Printf("expiry sweep: failed to delete blob %s: %v", sha, err)
+		} else if !deleted {
+			log.Printf("expiry sweep: blob %s was re-referenced before the sweep could delete it, skipping", sha)
+		}
+	}
+	return nil
+}
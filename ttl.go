@@ -0,0 +1,42 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// parseExpiry honors the X-Expiry-Seconds header (seconds from now) or the
+// standard Expires header (an HTTP-date) on a PUT, in that order of
+// precedence. It returns the zero Time if the object should never expire.
+func parseExpiry(h http.Header) (time.Time, error) {
+	if s := h.Get("X-Expiry-Seconds"); s != "" {
+		secs, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || secs < 0 {
+			return time.Time{}, fmt.Errorf("invalid X-Expiry-Seconds")
+		}
+		return time.Now().UTC().Add(time.Duration(secs) * time.Second), nil
+	}
+	if s := h.Get("Expires"); s != "" {
+		t, err := http.ParseTime(s)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid Expires header")
+		}
+		return t.UTC(), nil
+	}
+	return time.Time{}, nil
+}
+
+// generateDeleteKey returns a random token an uploader must present on
+// DELETE to prove ownership, in the style of transfer/paste services that
+// hand back a one-time delete link.
+func generateDeleteKey() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
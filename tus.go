@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// tusState tracks the progress of a single resumable upload. It lives
+// alongside the in-progress data file as a small sidecar JSON file so a
+// HEAD request can report Upload-Offset without re-stat'ing the data.
+type tusState struct {
+	Length   int64  `json:"length"`
+	Offset   int64  `json:"offset"`
+	Metadata string `json:"metadata"`
+}
+
+const (
+	tusResumableVersion = "1.0.0"
+	tusExtensions       = "creation,creation-with-upload,checksum,termination"
+)
+
+func tusDataPath(id string) string  { return filepath.Join(storeDir, "tmp", id+".tus") }
+func tusStatePath(id string) string { return filepath.Join(storeDir, "tmp", id+".tus.json") }
+
+func newTusUploadID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func tusSetCommonHeaders(w http.ResponseWriter) {
+	w.Header().Set("Tus-Resumable", tusResumableVersion)
+}
+
+func readTusState(id string) (tusState, error) {
+	var st tusState
+	b, err := os.ReadFile(tusStatePath(id))
+	if err != nil {
+		return st, err
+	}
+	err = json.Unmarshal(b, &st)
+	return st, err
+}
+
+func writeTusState(id string, st tusState) error {
+	b, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(tusStatePath(id), b, 0o644)
+}
+
+// handleTus implements the parts of the tus 1.0 resumable upload protocol
+// needed to survive network interruptions on large blob uploads:
+// creation, offset-based PATCH appends, checksum verification on
+// completion, and termination. It is mounted at /files/.
+func handleTus(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		tusSetCommonHeaders(w)
+		w.Header().Set("Tus-Version", tusResumableVersion)
+		w.Header().Set("Tus-Extension", tusExtensions)
+		if max, ok := tusMaxSize(); ok {
+			w.Header().Set("Tus-Max-Size", strconv.FormatInt(max, 10))
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	tusSetCommonHeaders(w)
+
+	id := strings.TrimPrefix(r.URL.Path, "/files/")
+
+	switch r.Method {
+	case http.MethodPost:
+		if id != "" {
+			http.Error(w, "upload id not allowed on creation", http.StatusBadRequest)
+			return
+		}
+		handleTusCreate(w, r)
+	case http.MethodHead:
+		if !uploadIDRe.MatchString(id) {
+			http.Error(w, "invalid upload id", http.StatusBadRequest)
+			return
+		}
+		handleTusHead(w, r, id)
+	case http.MethodPatch:
+		if !uploadIDRe.MatchString(id) {
+			http.Error(w, "invalid upload id", http.StatusBadRequest)
+			return
+		}
+		handleTusPatch(w, r, id)
+	case http.MethodDelete:
+		if !uploadIDRe.MatchString(id) {
+			http.Error(w, "invalid upload id", http.StatusBadRequest)
+			return
+		}
+		handleTusTerminate(w, r, id)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// tusMaxSize reports the configured TUS_MAX_SIZE, if any. Unlike the
+// reference tus server's default, we don't advertise a Tus-Max-Size of 0
+// when unconfigured — that reads to spec-following clients as "no uploads
+// accepted" and would reject every real upload before it starts.
+func tusMaxSize() (int64, bool) {
+	s := getenv("TUS_MAX_SIZE", "")
+	if s == "" {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+	return n, true
+}
+
+func handleTusCreate(w http.ResponseWriter, r *http.Request) {
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		http.Error(w, "missing or invalid Upload-Length", http.StatusBadRequest)
+		return
+	}
+	if max, ok := tusMaxSize(); ok && length > max {
+		http.Error(w, "Upload-Length exceeds Tus-Max-Size", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Join(storeDir, "tmp"), 0o755); err != nil {
+		http.Error(w, "failed to prepare upload", http.StatusInternalServerError)
+		return
+	}
+
+	id, err := newTusUploadID()
+	if err != nil {
+		http.Error(w, "failed to allocate upload id", http.StatusInternalServerError)
+		return
+	}
+
+	f, err := os.Create(tusDataPath(id))
+	if err != nil {
+		http.Error(w, "failed to create upload", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	var offset int64
+	// creation-with-upload: the POST may already carry the first bytes.
+	if ct := r.Header.Get("Content-Type"); ct == "application/offset+octet-stream" && r.ContentLength != 0 {
+		defer r.Body.Close()
+		n, err := io.Copy(f, io.LimitReader(r.Body, length))
+		if err != nil {
+			http.Error(w, "failed to write initial chunk", http.StatusInternalServerError)
+			return
+		}
+		offset = n
+	}
+
+	st := tusState{Length: length, Offset: offset, Metadata: r.Header.Get("Upload-Metadata")}
+	if err := writeTusState(id, st); err != nil {
+		http.Error(w, "failed to persist upload state", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Location", "/files/"+id)
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	if offset == length {
+		if err := finishTusUpload(w, r, id, st); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+func handleTusHead(w http.ResponseWriter, r *http.Request, id string) {
+	st, err := readTusState(id)
+	if err != nil {
+		http.Error(w, "upload not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(st.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(st.Length, 10))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
+
+func handleTusPatch(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		http.Error(w, "unsupported Content-Type", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	reqOffset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		http.Error(w, "missing or invalid Upload-Offset", http.StatusBadRequest)
+		return
+	}
+
+	st, err := readTusState(id)
+	if err != nil {
+		http.Error(w, "upload not found", http.StatusNotFound)
+		return
+	}
+	if reqOffset != st.Offset {
+		http.Error(w, "offset mismatch", http.StatusConflict)
+		return
+	}
+
+	f, err := os.OpenFile(tusDataPath(id), os.O_WRONLY, 0o644)
+	if err != nil {
+		http.Error(w, "upload data missing", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(st.Offset, io.SeekStart); err != nil {
+		http.Error(w, "seek failed", http.StatusInternalServerError)
+		return
+	}
+
+	defer r.Body.Close()
+	n, err := io.Copy(f, io.LimitReader(r.Body, st.Length-st.Offset))
+	if err != nil {
+		http.Error(w, "write failed", http.StatusInternalServerError)
+		return
+	}
+	st.Offset += n
+
+	if err := writeTusState(id, st); err != nil {
+		http.Error(w, "failed to persist upload state", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(st.Offset, 10))
+
+	if st.Offset == st.Length {
+		if err := finishTusUpload(w, r, id, st); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handleTusTerminate(w http.ResponseWriter, r *http.Request, id string) {
+	if _, err := readTusState(id); err != nil {
+		http.Error(w, "upload not found", http.StatusNotFound)
+		return
+	}
+	os.Remove(tusDataPath(id))
+	os.Remove(tusStatePath(id))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// finishTusUpload verifies the optional checksum extension header, then
+// promotes the finished temp file through the same hashing + atomic
+// rename path every other upload goes through, via the configured
+// Backend. It sets the response's Upload-Metadata header to the
+// resulting content-addressed id before the caller writes its status.
+func finishTusUpload(w http.ResponseWriter, r *http.Request, id string, st tusState) error {
+	dataPath := tusDataPath(id)
+
+	if want := r.Header.Get("Upload-Checksum"); want != "" {
+		ok, err := verifyTusChecksum(dataPath, want)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("checksum mismatch")
+		}
+	}
+
+	f, err := os.Open(dataPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sha, size, ct, err := storePutAndRef(r.Context(), f)
+	if err != nil {
+		return err
+	}
+
+	os.Remove(dataPath)
+	os.Remove(tusStatePath(id))
+
+	w.Header().Set("Upload-Metadata", fmt.Sprintf("id %s,size %s,content_type %s",
+		base64.StdEncoding.EncodeToString([]byte(sha)),
+		base64.StdEncoding.EncodeToString([]byte(strconv.FormatInt(size, 10))),
+		base64.StdEncoding.EncodeToString([]byte(ct)),
+	))
+	return nil
+}
+
+func verifyTusChecksum(path, header string) (bool, error) {
+	parts := strings.SplitN(header, " ", 2)
+	if len(parts) != 2 || parts[0] != "sha256" {
+		return false, fmt.Errorf("unsupported checksum algorithm")
+	}
+	want, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return false, fmt.Errorf("invalid Upload-Checksum encoding")
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return false, err
+	}
+
+	return bytes.Equal(h.Sum(nil), want), nil
+}
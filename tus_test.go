@@ -0,0 +1,138 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestTusCreatePatchComplete(t *testing.T) {
+	setupTestServer(t)
+
+	body := "the quick brown fox jumps over the lazy dog"
+	sum := sha256.Sum256([]byte(body))
+	wantSHA := hex.EncodeToString(sum[:])
+
+	createReq := httptest.NewRequest(http.MethodPost, "/files/", nil)
+	createReq.Header.Set("Upload-Length", strconv.Itoa(len(body)))
+	createW := httptest.NewRecorder()
+	handleTus(createW, createReq)
+	if createW.Code != http.StatusCreated {
+		t.Fatalf("create: got status %d, want 201", createW.Code)
+	}
+	loc := createW.Header().Get("Location")
+	id := strings.TrimPrefix(loc, "/files/")
+	if id == "" {
+		t.Fatalf("create: no Location header in response")
+	}
+
+	headReq := httptest.NewRequest(http.MethodHead, "/files/"+id, nil)
+	headW := httptest.NewRecorder()
+	handleTus(headW, headReq)
+	if headW.Code != http.StatusOK {
+		t.Fatalf("head: got status %d, want 200", headW.Code)
+	}
+	if got := headW.Header().Get("Upload-Offset"); got != "0" {
+		t.Fatalf("head: Upload-Offset = %q, want \"0\"", got)
+	}
+	if got := headW.Header().Get("Upload-Length"); got != strconv.Itoa(len(body)) {
+		t.Fatalf("head: Upload-Length = %q, want %q", got, strconv.Itoa(len(body)))
+	}
+
+	patchReq := httptest.NewRequest(http.MethodPatch, "/files/"+id, strings.NewReader(body))
+	patchReq.Header.Set("Content-Type", "application/offset+octet-stream")
+	patchReq.Header.Set("Upload-Offset", "0")
+	checksum := sha256.Sum256([]byte(body))
+	patchReq.Header.Set("Upload-Checksum", "sha256 "+base64.StdEncoding.EncodeToString(checksum[:]))
+	patchW := httptest.NewRecorder()
+	handleTus(patchW, patchReq)
+	if patchW.Code != http.StatusNoContent {
+		t.Fatalf("patch: got status %d, want 204, body: %s", patchW.Code, patchW.Body.String())
+	}
+
+	meta := patchW.Header().Get("Upload-Metadata")
+	if meta == "" {
+		t.Fatalf("patch: completed upload has no Upload-Metadata header")
+	}
+	var gotSHA string
+	for _, kv := range strings.Split(meta, ",") {
+		k, v, ok := strings.Cut(kv, " ")
+		if !ok || k != "id" {
+			continue
+		}
+		b, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			t.Fatalf("decoding id from Upload-Metadata: %v", err)
+		}
+		gotSHA = string(b)
+	}
+	if gotSHA != wantSHA {
+		t.Fatalf("completed upload sha = %q, want %q", gotSHA, wantSHA)
+	}
+
+	// The underlying blob must actually be retrievable by that id now.
+	if exists, err := store.Exists(createReq.Context(), wantSHA); err != nil || !exists {
+		t.Fatalf("blob %s missing after tus completion (exists=%v, err=%v)", wantSHA, exists, err)
+	}
+}
+
+func TestTusPatchChecksumMismatchRejected(t *testing.T) {
+	setupTestServer(t)
+
+	body := "some bytes"
+	createReq := httptest.NewRequest(http.MethodPost, "/files/", nil)
+	createReq.Header.Set("Upload-Length", strconv.Itoa(len(body)))
+	createW := httptest.NewRecorder()
+	handleTus(createW, createReq)
+	id := strings.TrimPrefix(createW.Header().Get("Location"), "/files/")
+
+	patchReq := httptest.NewRequest(http.MethodPatch, "/files/"+id, strings.NewReader(body))
+	patchReq.Header.Set("Content-Type", "application/offset+octet-stream")
+	patchReq.Header.Set("Upload-Offset", "0")
+	patchReq.Header.Set("Upload-Checksum", "sha256 "+base64.StdEncoding.EncodeToString([]byte("not-the-real-checksum!!")))
+	patchW := httptest.NewRecorder()
+	handleTus(patchW, patchReq)
+	if patchW.Code != http.StatusInternalServerError {
+		t.Fatalf("patch with bad checksum: got status %d, want %d", patchW.Code, http.StatusInternalServerError)
+	}
+}
+
+func TestTusRejectsPathTraversalID(t *testing.T) {
+	setupTestServer(t)
+
+	req := httptest.NewRequest(http.MethodHead, "/files/../../etc/passwd", nil)
+	w := httptest.NewRecorder()
+	handleTus(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("HEAD with path-traversal id: got status %d, want 400", w.Code)
+	}
+}
+
+func TestTusTerminate(t *testing.T) {
+	setupTestServer(t)
+
+	createReq := httptest.NewRequest(http.MethodPost, "/files/", nil)
+	createReq.Header.Set("Upload-Length", "10")
+	createW := httptest.NewRecorder()
+	handleTus(createW, createReq)
+	id := strings.TrimPrefix(createW.Header().Get("Location"), "/files/")
+
+	delReq := httptest.NewRequest(http.MethodDelete, "/files/"+id, nil)
+	delW := httptest.NewRecorder()
+	handleTus(delW, delReq)
+	if delW.Code != http.StatusNoContent {
+		t.Fatalf("terminate: got status %d, want 204", delW.Code)
+	}
+
+	headReq := httptest.NewRequest(http.MethodHead, "/files/"+id, nil)
+	headW := httptest.NewRecorder()
+	handleTus(headW, headReq)
+	if headW.Code != http.StatusNotFound {
+		t.Fatalf("head after terminate: got status %d, want 404", headW.Code)
+	}
+}